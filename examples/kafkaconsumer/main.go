@@ -25,7 +25,7 @@ var (
 
 func init() {
 	sarama.Logger = log.New(os.Stdout, "[sarama]        ", log.LstdFlags|log.Lshortfile)
-	kafkaconsumer.Logger = log.New(os.Stdout, "[kafkaconsumer] ", log.LstdFlags|log.Lshortfile)
+	kafkaconsumer.SetLogger(kafkaconsumer.NewStdLogger(log.New(os.Stdout, "[kafkaconsumer] ", log.LstdFlags|log.Lshortfile)))
 }
 
 func main() {
@@ -0,0 +1,97 @@
+// Package prometheus adapts kafkaconsumer's metrics to
+// prometheus/client_golang, for consumers of the kafkaconsumer package that
+// already expose a /metrics endpoint via Prometheus.
+//
+//	registry := prometheusadapter.New()
+//	config.MetricsRegistry = registry
+//	http.Handle("/metrics", promhttp.Handler())
+package prometheus
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsRegistry implements kafkaconsumer.MetricsRegistry using
+// prometheus/client_golang. Its metrics self-register with the default
+// Prometheus registerer on construction.
+type MetricsRegistry struct {
+	messagesTotal         *prometheus.CounterVec
+	lastConsumedOffset    *prometheus.GaugeVec
+	lastCommittedOffset   *prometheus.GaugeVec
+	highWaterMark         *prometheus.GaugeVec
+	processingWaitSeconds *prometheus.HistogramVec
+	partitionOwnerChanges *prometheus.CounterVec
+}
+
+// New creates and registers a MetricsRegistry with prometheus.DefaultRegisterer.
+func New() *MetricsRegistry {
+	labels := []string{"topic", "partition"}
+
+	r := &MetricsRegistry{
+		messagesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_messages_total",
+			Help: "Total number of messages consumed, per topic and partition.",
+		}, labels),
+		lastConsumedOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_last_consumed_offset",
+			Help: "The last offset consumed, per topic and partition.",
+		}, labels),
+		lastCommittedOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_last_committed_offset",
+			Help: "The last offset committed, per topic and partition.",
+		}, labels),
+		highWaterMark: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_high_water_mark",
+			Help: "The partition's current high water mark, per topic and partition.",
+		}, labels),
+		processingWaitSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "kafka_consumer_processing_wait_seconds",
+			Help: "Time spent waiting for in-flight messages to be acknowledged during shutdown.",
+		}, labels),
+		partitionOwnerChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_partition_owner_changes_total",
+			Help: "Total number of times this instance observed a partition's ownership change.",
+		}, labels),
+	}
+
+	prometheus.MustRegister(
+		r.messagesTotal,
+		r.lastConsumedOffset,
+		r.lastCommittedOffset,
+		r.highWaterMark,
+		r.processingWaitSeconds,
+		r.partitionOwnerChanges,
+	)
+
+	return r
+}
+
+func (r *MetricsRegistry) IncMessagesConsumed(topic string, partition int32) {
+	r.messagesTotal.WithLabelValues(topic, partitionLabel(partition)).Inc()
+}
+
+func (r *MetricsRegistry) SetLastConsumedOffset(topic string, partition int32, offset int64) {
+	r.lastConsumedOffset.WithLabelValues(topic, partitionLabel(partition)).Set(float64(offset))
+}
+
+func (r *MetricsRegistry) SetLastCommittedOffset(topic string, partition int32, offset int64) {
+	r.lastCommittedOffset.WithLabelValues(topic, partitionLabel(partition)).Set(float64(offset))
+}
+
+func (r *MetricsRegistry) SetHighWaterMark(topic string, partition int32, offset int64) {
+	r.highWaterMark.WithLabelValues(topic, partitionLabel(partition)).Set(float64(offset))
+}
+
+func (r *MetricsRegistry) ObserveProcessingWait(topic string, partition int32, seconds float64) {
+	r.processingWaitSeconds.WithLabelValues(topic, partitionLabel(partition)).Observe(seconds)
+}
+
+func (r *MetricsRegistry) IncPartitionOwnerChanges(topic string, partition int32) {
+	r.partitionOwnerChanges.WithLabelValues(topic, partitionLabel(partition)).Inc()
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.FormatInt(int64(partition), 10)
+}
@@ -0,0 +1,51 @@
+// Package zap adapts *zap.Logger to the kafkaconsumer.Logger interface, so
+// that consumers of the kafkaconsumer package can have its structured log
+// lines flow into their existing zap-based logging pipeline.
+//
+//	kafkaconsumer.SetLogger(zapadapter.New(zapLogger))
+package zap
+
+import (
+	"github.com/wvanbergen/kafka/kafkaconsumer"
+	"go.uber.org/zap"
+)
+
+// Logger adapts a *zap.Logger to kafkaconsumer.Logger.
+type Logger struct {
+	logger *zap.Logger
+}
+
+// New returns a kafkaconsumer.Logger backed by logger.
+func New(logger *zap.Logger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Debug(msg string, fields ...kafkaconsumer.Field) {
+	l.logger.Debug(msg, toZap(fields)...)
+}
+func (l *Logger) Info(msg string, fields ...kafkaconsumer.Field) {
+	l.logger.Info(msg, toZap(fields)...)
+}
+func (l *Logger) Warn(msg string, fields ...kafkaconsumer.Field) {
+	l.logger.Warn(msg, toZap(fields)...)
+}
+func (l *Logger) Error(msg string, fields ...kafkaconsumer.Field) {
+	l.logger.Error(msg, toZap(fields)...)
+}
+
+func toZap(fields []kafkaconsumer.Field) []zap.Field {
+	zapFields := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case kafkaconsumer.StringType:
+			zapFields[i] = zap.String(f.Key, f.String)
+		case kafkaconsumer.Int64Type:
+			zapFields[i] = zap.Int64(f.Key, f.Int64)
+		case kafkaconsumer.ErrorType:
+			zapFields[i] = zap.Error(f.Error)
+		case kafkaconsumer.StringerType:
+			zapFields[i] = zap.Stringer(f.Key, f.Stringer)
+		}
+	}
+	return zapFields
+}
@@ -0,0 +1,70 @@
+package kafkaconsumer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Batch drains up to maxSize messages from the consumer's message channel,
+// returning early once maxWait has elapsed since the call started. It may
+// return fewer than maxSize messages, including zero, if maxWait elapses
+// before enough messages arrive. It returns whatever has been collected so
+// far once the consumer is closed.
+//
+// Batch requires Config.Mode == SharedChannel: under PartitionChannels,
+// messages are only ever delivered through the per-partition channels
+// returned by Consumer.Partitions(), so c.messages never receives anything
+// and Batch would otherwise block until maxWait on every call, forever.
+// Batch panics if called in PartitionChannels mode.
+func (c *Consumer) Batch(maxSize int, maxWait time.Duration) []*sarama.ConsumerMessage {
+	if c.config.Mode == PartitionChannels {
+		panic("kafkaconsumer: Batch is not supported in PartitionChannels mode; use Partitions() instead")
+	}
+
+	batch := make([]*sarama.ConsumerMessage, 0, maxSize)
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	for len(batch) < maxSize {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, msg)
+
+		case <-timer.C:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// AckBatch acknowledges messages in a single call. It groups messages by
+// (topic, partition) and only acknowledges the highest offset seen per
+// partition, since acknowledging that offset implies every earlier offset in
+// the same partition has also been processed.
+//
+// AckBatch itself works with messages from either mode, but is only useful
+// alongside Batch, which requires Config.Mode == SharedChannel.
+func (c *Consumer) AckBatch(messages []*sarama.ConsumerMessage) {
+	type partitionKey struct {
+		topic     string
+		partition int32
+	}
+
+	byPartition := make(map[partitionKey][]*sarama.ConsumerMessage)
+	for _, msg := range messages {
+		key := partitionKey{msg.Topic, msg.Partition}
+		byPartition[key] = append(byPartition[key], msg)
+	}
+
+	for _, msgs := range byPartition {
+		sort.Slice(msgs, func(i, j int) bool { return msgs[i].Offset < msgs[j].Offset })
+		c.Ack(msgs[len(msgs)-1])
+	}
+}
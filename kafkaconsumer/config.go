@@ -0,0 +1,68 @@
+package kafkaconsumer
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Config configures a Consumer started by Join. The zero value is not ready
+// to use; call NewConfig to get sensible defaults.
+type Config struct {
+	// Config is the Sarama configuration used for the client, consumer, and
+	// (under CoordinationKafka) consumer group this package builds on top
+	// of.
+	*sarama.Config
+
+	Offsets struct {
+		// Initial is the offset partitionManager starts consuming from when
+		// no prior offset is on file for a partition, e.g.
+		// sarama.OffsetOldest or sarama.OffsetNewest.
+		Initial int64
+	}
+
+	// MaxProcessingTime bounds how long a partitionManager waits, during
+	// shutdown, for its last consumed offset to be acknowledged before
+	// giving up on it.
+	MaxProcessingTime time.Duration
+
+	// Coordination selects how group membership and partition ownership are
+	// coordinated. Defaults to CoordinationZookeeper.
+	Coordination CoordinationMode
+
+	// Mode selects how assigned partitions hand their messages off to the
+	// application. Defaults to SharedChannel.
+	Mode ConsumerMode
+
+	// OffsetStore, when set, commits and loads offsets through a
+	// PartitionStorer instead of Coordination's own offset storage. Leaving
+	// it nil preserves each mode's original behavior: committing through a
+	// Sarama PartitionOffsetManager, against Zookeeper-backed consumer
+	// group offsets under CoordinationZookeeper, or against
+	// __consumer_offsets under CoordinationKafka.
+	OffsetStore PartitionStorer
+
+	// MetricsRegistry, when set, receives per-partition consumption and
+	// coordination metrics. All metrics bookkeeping is skipped when nil.
+	MetricsRegistry MetricsRegistry
+}
+
+// NewConfig returns a Config with Sarama's own defaults plus this package's:
+// consuming from the oldest available offset, a 60 second
+// MaxProcessingTime, CoordinationZookeeper and SharedChannel.
+func NewConfig() *Config {
+	config := &Config{Config: sarama.NewConfig()}
+	config.Offsets.Initial = sarama.OffsetOldest
+	config.MaxProcessingTime = 60 * time.Second
+	return config
+}
+
+// Subscription is the set of topics a Consumer consumes, built with
+// TopicSubscription.
+type Subscription []string
+
+// TopicSubscription builds a Subscription for Join from a list of topic
+// names.
+func TopicSubscription(topics ...string) Subscription {
+	return Subscription(topics)
+}
@@ -0,0 +1,359 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/wvanbergen/kazoo-go"
+	"gopkg.in/tomb.v1"
+)
+
+// Consumer is the handle returned by Join. It embeds *consumerManager,
+// promoting the fields partitionManager and this package's other Consumer
+// methods (Batch, AckBatch, Partitions) read and write directly.
+type Consumer struct {
+	*consumerManager
+}
+
+// consumerManager owns group membership, the set of currently running
+// partitionManagers, and the channels partitionManagers hand messages and
+// errors off on.
+type consumerManager struct {
+	config     *Config
+	groupID    string
+	instanceID string
+	topics     []string
+
+	kazoo         *kazoo.Kazoo
+	client        sarama.Client
+	consumer      sarama.Consumer
+	offsetManager sarama.OffsetManager
+	coordinator   Coordinator
+
+	messages   chan *sarama.ConsumerMessage
+	errors     chan error
+	partitions chan PartitionConsumer
+
+	mu       sync.Mutex
+	managers map[TopicPartition]*partitionManager
+
+	t tomb.Tomb
+}
+
+// Join starts a Consumer in group, consuming every topic in subscription.
+// connect is a comma-separated list of addresses used to reach the cluster:
+// Zookeeper host:port pairs under CoordinationZookeeper (the default), used
+// both to discover the Kafka brokers and to coordinate group membership, or
+// Kafka broker host:port pairs under CoordinationKafka, which coordinates
+// group membership through Kafka itself and needs no Zookeeper access.
+func Join(group string, subscription Subscription, connect string, config *Config) (*Consumer, error) {
+	if config == nil {
+		config = NewConfig()
+	}
+
+	instanceID, err := generateInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &consumerManager{
+		config:     config,
+		groupID:    group,
+		instanceID: instanceID,
+		topics:     []string(subscription),
+		messages:   make(chan *sarama.ConsumerMessage),
+		errors:     make(chan error),
+		managers:   make(map[TopicPartition]*partitionManager),
+	}
+	if config.Mode == PartitionChannels {
+		cm.partitions = make(chan PartitionConsumer)
+	}
+
+	var assignment Assignment
+	switch config.Coordination {
+	case CoordinationKafka:
+		assignment, err = cm.joinKafka(strings.Split(connect, ","))
+	default:
+		assignment, err = cm.joinZookeeper(strings.Split(connect, ","))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config.OffsetStore == nil {
+		cm.offsetManager, err = sarama.NewOffsetManagerFromClient(group, cm.client)
+		if err != nil {
+			cm.teardown()
+			return nil, fmt.Errorf("failed to start offset manager: %s", err)
+		}
+	}
+
+	go cm.run(assignment)
+
+	return &Consumer{cm}, nil
+}
+
+// joinZookeeper connects to Zookeeper, discovers the Kafka broker list from
+// it, and registers this instance with group via zkCoordinator.
+func (cm *consumerManager) joinZookeeper(zookeeper []string) (Assignment, error) {
+	kz, err := kazoo.NewKazoo(zookeeper, nil)
+	if err != nil {
+		return Assignment{}, fmt.Errorf("failed to connect to Zookeeper: %s", err)
+	}
+	cm.kazoo = kz
+
+	brokers, err := kz.BrokerList()
+	if err != nil {
+		return Assignment{}, fmt.Errorf("failed to discover Kafka brokers from Zookeeper: %s", err)
+	}
+
+	if err := cm.connect(brokers); err != nil {
+		return Assignment{}, err
+	}
+
+	group := kz.Consumergroup(cm.groupID)
+	instance := group.Instance(cm.instanceID)
+	cm.coordinator = newZKCoordinator(group, instance, cm.config.MetricsRegistry)
+
+	ctx, cancel := cm.dyingContext()
+	defer cancel()
+	return cm.coordinator.Join(ctx, cm.topics)
+}
+
+// joinKafka connects directly to the given Kafka brokers and registers this
+// instance with group via kafkaCoordinator, using Kafka's native group
+// coordinator protocol instead of Zookeeper.
+func (cm *consumerManager) joinKafka(brokers []string) (Assignment, error) {
+	if err := cm.connect(brokers); err != nil {
+		return Assignment{}, err
+	}
+
+	coordinator, err := newKafkaCoordinator(brokers, cm.groupID, cm.config.Config, cm.config.MetricsRegistry)
+	if err != nil {
+		return Assignment{}, err
+	}
+	cm.coordinator = coordinator
+
+	ctx, cancel := cm.dyingContext()
+	defer cancel()
+	return cm.coordinator.Join(ctx, cm.topics)
+}
+
+// connect starts the Sarama client and consumer this Consumer's
+// partitionManagers share.
+func (cm *consumerManager) connect(brokers []string) error {
+	client, err := sarama.NewClient(brokers, cm.config.Config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kafka: %s", err)
+	}
+	cm.client = client
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to start Kafka consumer: %s", err)
+	}
+	cm.consumer = consumer
+	return nil
+}
+
+// dyingContext returns a context canceled as soon as cm.t starts dying, so
+// that Join's blocking calls into the coordinator don't outlive an
+// interrupted startup.
+func (cm *consumerManager) dyingContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-cm.t.Dying():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// run keeps the running set of partitionManagers in sync with the
+// coordinator's assignment, until the Consumer is closed. Under
+// CoordinationKafka, it also applies every later Assignment the group
+// coordinator pushes on Reassigned, e.g. after a rebalance grants or revokes
+// partitions; under CoordinationZookeeper, Reassigned never fires, since
+// ownership there is arbitrated lazily through claimPartition instead.
+func (cm *consumerManager) run(assignment Assignment) {
+	defer cm.t.Done()
+	defer cm.teardown()
+
+	cm.sync(assignment)
+
+	reassigned := cm.coordinator.Reassigned()
+	for {
+		select {
+		case assignment := <-reassigned:
+			cm.sync(assignment)
+		case <-cm.t.Dying():
+			cm.stopAll()
+			return
+		}
+	}
+}
+
+// sync starts a partitionManager for every partition in target that isn't
+// already running, and stops every running partitionManager for a
+// partition no longer in target.
+func (cm *consumerManager) sync(assignment Assignment) {
+	target := cm.targetPartitions(assignment)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for tp, pm := range cm.managers {
+		if !target[tp] {
+			delete(cm.managers, tp)
+			go pm.close()
+		}
+	}
+
+	for tp := range target {
+		if _, ok := cm.managers[tp]; ok {
+			continue
+		}
+
+		pm := &partitionManager{
+			parent:             cm,
+			partition:          tp,
+			lastConsumedOffset: -1,
+			processingDone:     make(chan struct{}),
+		}
+		cm.managers[tp] = pm
+		go pm.run()
+	}
+}
+
+// targetPartitions resolves the set of partitions this instance should run a
+// partitionManager for. Under CoordinationKafka, assignment is exactly that
+// set, as granted by the group coordinator. Under CoordinationZookeeper,
+// assignment is always empty: every instance starts a partitionManager for
+// every partition of every subscribed topic instead, and zkCoordinator's
+// claimPartition arbitrates ownership through Zookeeper.
+func (cm *consumerManager) targetPartitions(assignment Assignment) map[TopicPartition]bool {
+	target := make(map[TopicPartition]bool)
+
+	if cm.config.Coordination == CoordinationKafka {
+		for _, tp := range assignment.Partitions {
+			target[tp] = true
+		}
+		return target
+	}
+
+	for _, topic := range cm.topics {
+		partitions, err := cm.kazoo.Topic(topic).Partitions()
+		if err != nil {
+			logger.Error("Failed to list partitions for topic.", String("topic", topic), Err(err))
+			continue
+		}
+		for _, p := range partitions {
+			target[TopicPartition{Topic: topic, Partition: p.ID}] = true
+		}
+	}
+	return target
+}
+
+// stopAll closes every currently running partitionManager and waits for
+// them to finish.
+func (cm *consumerManager) stopAll() {
+	cm.mu.Lock()
+	managers := make([]*partitionManager, 0, len(cm.managers))
+	for tp, pm := range cm.managers {
+		delete(cm.managers, tp)
+		managers = append(managers, pm)
+	}
+	cm.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pm := range managers {
+		wg.Add(1)
+		go func(pm *partitionManager) {
+			defer wg.Done()
+			pm.close()
+		}(pm)
+	}
+	wg.Wait()
+}
+
+// teardown releases every resource Join acquired, and closes the channels
+// Consumer exposes so that callers ranging over them observe a clean
+// end-of-stream.
+func (cm *consumerManager) teardown() {
+	if cm.coordinator != nil {
+		if err := cm.coordinator.Leave(context.Background()); err != nil {
+			logger.Error("Failed to leave consumer group.", Err(err))
+		}
+	}
+	if cm.offsetManager != nil {
+		cm.offsetManager.Close()
+	}
+	if cm.consumer != nil {
+		cm.consumer.Close()
+	}
+	if cm.client != nil {
+		cm.client.Close()
+	}
+	if cm.kazoo != nil {
+		cm.kazoo.Close()
+	}
+
+	close(cm.messages)
+	close(cm.errors)
+	if cm.partitions != nil {
+		close(cm.partitions)
+	}
+}
+
+// Messages returns the channel messages from every assigned partition are
+// published to, under Config.Mode == SharedChannel. It is closed once the
+// Consumer has fully shut down.
+func (c *Consumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// Errors returns the channel partition-level errors are published to. It is
+// closed once the Consumer has fully shut down.
+func (c *Consumer) Errors() <-chan error {
+	return c.errors
+}
+
+// Ack acknowledges msg as processed, committing its offset for msg's
+// partition.
+func (c *Consumer) Ack(msg *sarama.ConsumerMessage) {
+	c.mu.Lock()
+	pm := c.managers[TopicPartition{Topic: msg.Topic, Partition: msg.Partition}]
+	c.mu.Unlock()
+
+	if pm == nil {
+		logger.Warn("Ack for a partition this instance no longer owns; ignoring.",
+			String("topic", msg.Topic), Int64("partition", int64(msg.Partition)), Int64("offset", msg.Offset))
+		return
+	}
+	pm.ack(msg.Offset)
+}
+
+// Close stops consuming, waits for in-flight messages to be acknowledged
+// (up to Config.MaxProcessingTime per partition), and releases this
+// instance's resources.
+func (c *Consumer) Close() error {
+	c.t.Kill(nil)
+	return c.t.Wait()
+}
+
+// generateInstanceID builds a unique identifier for this process within the
+// consumer group, used for Zookeeper registration and log fields.
+func generateInstanceID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine hostname for instance ID: %s", err)
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid()), nil
+}
@@ -0,0 +1,78 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+)
+
+// CoordinationMode selects how a consumer group coordinates partition
+// ownership and offset commits across instances.
+type CoordinationMode int
+
+const (
+	// CoordinationZookeeper uses Zookeeper and kazoo for group membership,
+	// partition ownership and offset storage. This is this package's
+	// original behavior, and remains the default.
+	CoordinationZookeeper CoordinationMode = iota
+
+	// CoordinationKafka uses Kafka's native group coordinator protocol
+	// (JoinGroup/SyncGroup/Heartbeat/LeaveGroup) via Sarama, storing offsets
+	// in the __consumer_offsets topic. It requires no Zookeeper access, so
+	// it works against brokers that hide Zookeeper entirely (e.g. Confluent
+	// Cloud, MSK), and shares consumer groups with other Kafka clients.
+	CoordinationKafka
+)
+
+// TopicPartition identifies a single partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+// String renders tp as "topic/partition", for log messages and errors.
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s/%d", tp.Topic, tp.Partition)
+}
+
+// Assignment is the set of partitions a Coordinator has granted to this
+// instance.
+type Assignment struct {
+	Partitions []TopicPartition
+}
+
+// Coordinator abstracts group membership, partition assignment and offset
+// commits, so consumerManager can run against either Zookeeper (zkCoordinator)
+// or Kafka's native group coordinator (kafkaCoordinator). Config.Coordination
+// selects which implementation Join constructs.
+type Coordinator interface {
+	// Join registers this instance with the group. kafkaCoordinator blocks
+	// until it has been assigned a set of partitions across topics;
+	// zkCoordinator returns an empty Assignment as soon as it has registered.
+	Join(ctx context.Context, topics []string) (Assignment, error)
+
+	// Heartbeat signals that this instance is still alive and participating
+	// in the group. zkCoordinator implements it as a no-op, since kazoo ties
+	// liveness to its Zookeeper session instead of an explicit heartbeat.
+	Heartbeat(ctx context.Context) error
+
+	// Leave removes this instance from the group, releasing its assignment.
+	Leave(ctx context.Context) error
+
+	// CommitOffsets commits the given offsets on behalf of this instance.
+	CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error
+
+	// Reassigned returns a channel of every Assignment granted to this
+	// instance after the one Join itself returned, so consumerManager can
+	// start and stop partitionManagers to match. zkCoordinator has no
+	// concept of a pushed reassignment - partitions are claimed lazily and
+	// ownership changes are arbitrated through Zookeeper contention instead
+	// - so it returns a nil channel, which never fires.
+	Reassigned() <-chan Assignment
+
+	// claimPartition and releasePartition let partitionManager take and give
+	// up ownership of a single partition from its Assignment. For
+	// CoordinationKafka this is a no-op: ownership is already exclusive once
+	// Join returns an Assignment containing the partition.
+	claimPartition(ctx context.Context, topic string, partition int32) error
+	releasePartition(ctx context.Context, topic string, partition int32)
+}
@@ -0,0 +1,186 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaCoordinator implements Coordinator using Kafka's native group
+// coordinator protocol (JoinGroup/SyncGroup/Heartbeat/LeaveGroup), via
+// Sarama's ConsumerGroup. It needs no Zookeeper access.
+type kafkaCoordinator struct {
+	group   sarama.ConsumerGroup
+	groupID string
+	metrics MetricsRegistry
+
+	handler    *kafkaGroupHandler
+	cancel     context.CancelFunc
+	consumed   chan error
+	firstJoin  chan Assignment
+	reassigned chan Assignment
+}
+
+func newKafkaCoordinator(brokers []string, groupID string, config *sarama.Config, metrics MetricsRegistry) (*kafkaCoordinator, error) {
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer group: %s", err)
+	}
+
+	return &kafkaCoordinator{
+		group:      group,
+		groupID:    groupID,
+		metrics:    metrics,
+		handler:    newKafkaGroupHandler(),
+		consumed:   make(chan error, 1),
+		firstJoin:  make(chan Assignment, 1),
+		reassigned: make(chan Assignment),
+	}, nil
+}
+
+// Join starts Sarama's ConsumerGroup.Consume loop in the background, along
+// with a goroutine that keeps draining kafkaGroupHandler's assignments for
+// the lifetime of the session, and blocks until the group coordinator has
+// assigned this instance a set of partitions for the first time.
+//
+// Consume's Setup callback fires on every rebalance, not just the first
+// one, so the draining goroutine must keep running after Join returns:
+// leaving kafkaGroupHandler.assigned unread after the first rebalance would
+// block Setup on the next one, wedging Sarama's rebalance handshake for
+// this instance. Every assignment after the first is forwarded on
+// reassigned instead of being dropped, so consumerManager can start and
+// stop partitionManagers to match.
+func (c *kafkaCoordinator) Join(ctx context.Context, topics []string) (Assignment, error) {
+	consumeCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	go func() {
+		for consumeCtx.Err() == nil {
+			if err := c.group.Consume(consumeCtx, topics, c.handler); err != nil {
+				select {
+				case c.consumed <- err:
+				default:
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		first := true
+		for {
+			select {
+			case assignment := <-c.handler.assigned:
+				if first {
+					first = false
+					c.firstJoin <- assignment
+					continue
+				}
+				if c.metrics != nil {
+					for _, tp := range assignment.Partitions {
+						c.metrics.IncPartitionOwnerChanges(tp.Topic, tp.Partition)
+					}
+				}
+
+				select {
+				case c.reassigned <- assignment:
+				case <-consumeCtx.Done():
+					return
+				}
+			case <-consumeCtx.Done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case assignment := <-c.firstJoin:
+		return assignment, nil
+	case err := <-c.consumed:
+		return Assignment{}, err
+	case <-ctx.Done():
+		cancel()
+		return Assignment{}, ctx.Err()
+	}
+}
+
+// Reassigned returns a channel of every Assignment the group coordinator
+// grants this instance after the one Join itself returned.
+func (c *kafkaCoordinator) Reassigned() <-chan Assignment {
+	return c.reassigned
+}
+
+// Heartbeat is a no-op: Sarama's ConsumerGroup sends heartbeats to the group
+// coordinator on its own background ticker for as long as Consume is
+// running.
+func (c *kafkaCoordinator) Heartbeat(ctx context.Context) error {
+	return nil
+}
+
+func (c *kafkaCoordinator) Leave(ctx context.Context) error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return c.group.Close()
+}
+
+// CommitOffsets is a no-op: each partitionManager commits its own offset
+// through its own sarama.PartitionOffsetManager, or through
+// Config.OffsetStore when set.
+func (c *kafkaCoordinator) CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error {
+	return nil
+}
+
+// claimPartition is a no-op: by the time Join has returned an Assignment
+// containing partition, the group coordinator has already granted this
+// instance exclusive ownership of it for the session.
+func (c *kafkaCoordinator) claimPartition(ctx context.Context, topic string, partition int32) error {
+	return nil
+}
+
+// releasePartition is a no-op: ownership is released automatically when the
+// session ends, via Leave or the next rebalance.
+func (c *kafkaCoordinator) releasePartition(ctx context.Context, topic string, partition int32) {
+}
+
+// kafkaGroupHandler implements sarama.ConsumerGroupHandler, translating its
+// Setup callback - which fires once per rebalance, for the life of the
+// session - into a stream of Assignments on its assigned channel.
+type kafkaGroupHandler struct {
+	assigned chan Assignment
+}
+
+func newKafkaGroupHandler() *kafkaGroupHandler {
+	return &kafkaGroupHandler{assigned: make(chan Assignment)}
+}
+
+// Setup blocks until something reads the Assignment it sends, or the session
+// ends, rather than assuming the channel is read exactly once: Sarama calls
+// Setup again on every rebalance, not only the first one.
+func (h *kafkaGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	var assignment Assignment
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			assignment.Partitions = append(assignment.Partitions, TopicPartition{Topic: topic, Partition: partition})
+		}
+	}
+
+	select {
+	case h.assigned <- assignment:
+	case <-session.Context().Done():
+	}
+	return nil
+}
+
+func (h *kafkaGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim is unused: once a partition is in the Assignment, its
+// messages are consumed directly by partitionManager through
+// consumerManager.consumer (a sarama.Consumer), not through this claim.
+func (h *kafkaGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	<-session.Context().Done()
+	return nil
+}
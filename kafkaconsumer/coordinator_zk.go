@@ -0,0 +1,99 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wvanbergen/kazoo-go"
+)
+
+// zkCoordinator implements Coordinator using Zookeeper and kazoo. It
+// reproduces this package's original behavior, from before the Coordinator
+// abstraction existed.
+type zkCoordinator struct {
+	group    *kazoo.Consumergroup
+	instance *kazoo.ConsumergroupInstance
+	metrics  MetricsRegistry
+}
+
+func newZKCoordinator(group *kazoo.Consumergroup, instance *kazoo.ConsumergroupInstance, metrics MetricsRegistry) *zkCoordinator {
+	return &zkCoordinator{group: group, instance: instance, metrics: metrics}
+}
+
+// Join registers this instance in Zookeeper. Partition ownership itself is
+// not decided here: each partitionManager claims its own partition lazily,
+// via claimPartition, once consumerManager has worked out the partition
+// division.
+func (c *zkCoordinator) Join(ctx context.Context, topics []string) (Assignment, error) {
+	if err := c.instance.Register(topics); err != nil {
+		return Assignment{}, fmt.Errorf("failed to register consumer instance in Zookeeper: %s", err)
+	}
+	return Assignment{}, nil
+}
+
+// Heartbeat is a no-op: kazoo ties liveness to the Zookeeper session it
+// maintains, rather than an explicit heartbeat call.
+func (c *zkCoordinator) Heartbeat(ctx context.Context) error {
+	return nil
+}
+
+func (c *zkCoordinator) Leave(ctx context.Context) error {
+	return c.instance.Deregister()
+}
+
+// CommitOffsets is a no-op: offsets are committed per-partition through each
+// partitionManager's own sarama.PartitionOffsetManager, or through
+// Config.OffsetStore when set.
+func (c *zkCoordinator) CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error {
+	return nil
+}
+
+// Reassigned returns nil: zkCoordinator never pushes a reassignment, since
+// each partitionManager claims and releases its own partition lazily through
+// claimPartition instead of waiting on a granted Assignment. A nil channel
+// never fires, so selecting on it is a permanent no-op.
+func (c *zkCoordinator) Reassigned() <-chan Assignment {
+	return nil
+}
+
+// claimPartition blocks until this instance owns partition in Zookeeper,
+// waiting for the current owner to release it if necessary.
+func (c *zkCoordinator) claimPartition(ctx context.Context, topic string, partition int32) error {
+	for {
+		owner, changed, err := c.group.WatchPartitionOwner(topic, partition)
+		if err != nil {
+			select {
+			case <-time.After(1 * time.Second):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if owner != nil {
+			if owner.ID == c.instance.ID {
+				return fmt.Errorf("the current instance is already the owner of %s/%d. This should not happen.", topic, partition)
+			}
+
+			select {
+			case <-changed:
+				if c.metrics != nil {
+					c.metrics.IncPartitionOwnerChanges(topic, partition)
+				}
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := c.instance.ClaimPartition(topic, partition); err != nil {
+			continue
+		}
+		return nil
+	}
+}
+
+func (c *zkCoordinator) releasePartition(ctx context.Context, topic string, partition int32) {
+	c.instance.ReleasePartition(topic, partition)
+}
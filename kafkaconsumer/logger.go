@@ -0,0 +1,115 @@
+package kafkaconsumer
+
+import (
+	"fmt"
+	stdlog "log"
+	"os"
+)
+
+// FieldType identifies the kind of value carried by a Field, so adapters can
+// type-switch on it without resorting to a reflection-based interface{}.
+type FieldType int
+
+const (
+	StringType FieldType = iota
+	Int64Type
+	ErrorType
+	StringerType
+)
+
+// Field is a structured key/value pair attached to a log line. Use the
+// String, Int64, Err and Stringer constructors to build one.
+type Field struct {
+	Key      string
+	Type     FieldType
+	String   string
+	Int64    int64
+	Error    error
+	Stringer fmt.Stringer
+}
+
+// String creates a Field carrying a string value.
+func String(key, value string) Field {
+	return Field{Key: key, Type: StringType, String: value}
+}
+
+// Int64 creates a Field carrying an int64 value.
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Type: Int64Type, Int64: value}
+}
+
+// Err creates a Field carrying an error, under the conventional key "error".
+func Err(err error) Field {
+	return Field{Key: "error", Type: ErrorType, Error: err}
+}
+
+// Stringer creates a Field carrying a fmt.Stringer, evaluated lazily by the
+// adapter only if it actually logs the line.
+func Stringer(key string, value fmt.Stringer) Field {
+	return Field{Key: key, Type: StringerType, Stringer: value}
+}
+
+// Value returns the field's value as an interface{}, for adapters that want
+// to hand fields to a generic structured logging library.
+func (f Field) Value() interface{} {
+	switch f.Type {
+	case StringType:
+		return f.String
+	case Int64Type:
+		return f.Int64
+	case ErrorType:
+		return f.Error
+	case StringerType:
+		return f.Stringer.String()
+	default:
+		return nil
+	}
+}
+
+// Logger is the structured logging interface used throughout this package.
+// It replaces the previous package-level *log.Logger, so that operators
+// running centralized logging can pivot on fields like topic and partition
+// instead of parsing formatted messages.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// logger is the package-level Logger used by partitionManager and friends.
+// It defaults to a StdLogger writing to os.Stderr. Replace it with SetLogger,
+// e.g. with an adapters/zap.Logger, to integrate with centralized logging.
+var logger Logger = NewStdLogger(stdlog.New(os.Stderr, "[kafkaconsumer] ", stdlog.LstdFlags))
+
+// SetLogger replaces the package-level Logger used by partitionManager and
+// friends. It is not safe to call concurrently with consumer operation;
+// call it once, before joining a consumer group.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// StdLogger adapts a standard library *log.Logger to the Logger interface,
+// so that existing users of this package keep working unchanged. Fields are
+// rendered as a trailing "key=value" list.
+type StdLogger struct {
+	logger *stdlog.Logger
+}
+
+// NewStdLogger returns a Logger backed by logger.
+func NewStdLogger(logger *stdlog.Logger) *StdLogger {
+	return &StdLogger{logger: logger}
+}
+
+func (l *StdLogger) Debug(msg string, fields ...Field) { l.print("DEBUG", msg, fields) }
+func (l *StdLogger) Info(msg string, fields ...Field)  { l.print("INFO", msg, fields) }
+func (l *StdLogger) Warn(msg string, fields ...Field)  { l.print("WARN", msg, fields) }
+func (l *StdLogger) Error(msg string, fields ...Field) { l.print("ERROR", msg, fields) }
+
+func (l *StdLogger) print(level, msg string, fields []Field) {
+	line := level + ": " + msg
+	for _, f := range fields {
+		line += fmt.Sprintf(" %s=%v", f.Key, f.Value())
+	}
+	l.logger.Println(line)
+}
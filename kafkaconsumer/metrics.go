@@ -0,0 +1,96 @@
+package kafkaconsumer
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// MetricsRegistry receives metric updates from partitionManager's hot paths.
+// It is opt-in: when Config.MetricsRegistry is nil, partitionManager skips
+// all metrics bookkeeping entirely.
+type MetricsRegistry interface {
+	// IncMessagesConsumed counts a message being handed off for processing.
+	IncMessagesConsumed(topic string, partition int32)
+
+	// SetLastConsumedOffset records the most recent offset consumed from
+	// the partition.
+	SetLastConsumedOffset(topic string, partition int32, offset int64)
+
+	// SetLastCommittedOffset records the most recent offset acknowledged
+	// (and committed) for the partition.
+	SetLastCommittedOffset(topic string, partition int32, offset int64)
+
+	// SetHighWaterMark records the partition's current high water mark, so
+	// that lag can be derived as high water mark minus committed offset.
+	SetHighWaterMark(topic string, partition int32, offset int64)
+
+	// ObserveProcessingWait records how long waitForProcessing waited for
+	// in-flight messages to be acknowledged during shutdown.
+	ObserveProcessingWait(topic string, partition int32, seconds float64)
+
+	// IncPartitionOwnerChanges counts this instance observing a partition's
+	// ownership change while trying to claim it.
+	IncPartitionOwnerChanges(topic string, partition int32)
+}
+
+// ExpvarMetricsRegistry is an in-tree MetricsRegistry. It is only used when
+// set as Config.MetricsRegistry. It exposes its counters under the standard
+// expvar HTTP handler, keyed by "topic:partition".
+type ExpvarMetricsRegistry struct {
+	messagesTotal         *expvar.Map
+	lastConsumedOffset    *expvar.Map
+	lastCommittedOffset   *expvar.Map
+	highWaterMark         *expvar.Map
+	processingWaitSeconds *expvar.Map
+	partitionOwnerChanges *expvar.Map
+}
+
+// NewExpvarMetricsRegistry creates and publishes a fresh ExpvarMetricsRegistry.
+// It must only be called once per process, since expvar.Publish panics on a
+// duplicate name.
+func NewExpvarMetricsRegistry() *ExpvarMetricsRegistry {
+	return &ExpvarMetricsRegistry{
+		messagesTotal:         expvar.NewMap("kafka_consumer_messages_total"),
+		lastConsumedOffset:    expvar.NewMap("kafka_consumer_last_consumed_offset"),
+		lastCommittedOffset:   expvar.NewMap("kafka_consumer_last_committed_offset"),
+		highWaterMark:         expvar.NewMap("kafka_consumer_high_water_mark"),
+		processingWaitSeconds: expvar.NewMap("kafka_consumer_processing_wait_seconds"),
+		partitionOwnerChanges: expvar.NewMap("kafka_consumer_partition_owner_changes_total"),
+	}
+}
+
+func partitionKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s:%d", topic, partition)
+}
+
+func (r *ExpvarMetricsRegistry) IncMessagesConsumed(topic string, partition int32) {
+	r.messagesTotal.Add(partitionKey(topic, partition), 1)
+}
+
+func (r *ExpvarMetricsRegistry) SetLastConsumedOffset(topic string, partition int32, offset int64) {
+	setExpvarInt(r.lastConsumedOffset, partitionKey(topic, partition), offset)
+}
+
+func (r *ExpvarMetricsRegistry) SetLastCommittedOffset(topic string, partition int32, offset int64) {
+	setExpvarInt(r.lastCommittedOffset, partitionKey(topic, partition), offset)
+}
+
+func (r *ExpvarMetricsRegistry) SetHighWaterMark(topic string, partition int32, offset int64) {
+	setExpvarInt(r.highWaterMark, partitionKey(topic, partition), offset)
+}
+
+func (r *ExpvarMetricsRegistry) ObserveProcessingWait(topic string, partition int32, seconds float64) {
+	var f expvar.Float
+	f.Set(seconds)
+	r.processingWaitSeconds.Set(partitionKey(topic, partition), &f)
+}
+
+func (r *ExpvarMetricsRegistry) IncPartitionOwnerChanges(topic string, partition int32) {
+	r.partitionOwnerChanges.Add(partitionKey(topic, partition), 1)
+}
+
+func setExpvarInt(m *expvar.Map, key string, value int64) {
+	var i expvar.Int
+	i.Set(value)
+	m.Set(key, &i)
+}
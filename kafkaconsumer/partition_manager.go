@@ -1,12 +1,12 @@
 package kafkaconsumer
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/wvanbergen/kazoo-go"
 	"gopkg.in/tomb.v1"
 )
 
@@ -15,7 +15,7 @@ import (
 type partitionManager struct {
 	parent    *consumerManager
 	t         tomb.Tomb
-	partition *kazoo.Partition
+	partition TopicPartition
 
 	offsetManager      sarama.PartitionOffsetManager
 	lastConsumedOffset int64
@@ -36,23 +36,40 @@ func (pm *partitionManager) run() {
 	}
 	defer pm.releasePartition()
 
-	offsetManager, err := pm.startPartitionOffsetManager()
-	if err != nil {
-		pm.t.Kill(err)
-		return
-	} else {
+	// Only start a Sarama offset manager when Config.OffsetStore is unset.
+	// Starting one unconditionally would open a live connection to Kafka/ZK
+	// offset storage for every partition even when the whole point of
+	// OffsetStore is to decouple from it.
+	var (
+		offsetManager sarama.PartitionOffsetManager
+		err           error
+	)
+	if pm.parent.config.OffsetStore == nil {
+		offsetManager, err = pm.startPartitionOffsetManager()
+		if err != nil {
+			pm.t.Kill(err)
+			return
+		}
 		pm.offsetManager = offsetManager
 		defer offsetManager.Close()
 	}
 
-	// We are ignoring metadata for now.
-	initialOffset, _ := offsetManager.Offset()
-	if initialOffset < 0 {
-		initialOffset = pm.parent.config.Offsets.Initial
-	} else {
-		// Fix the off by one error: we should start consuming once message after the last committed offset
-		initialOffset += 1
+	initialOffset, err := pm.loadInitialOffset(offsetManager)
+	if err != nil {
+		pm.t.Kill(err)
+		return
 	}
+
+	// When running in PartitionChannels mode, pcWrapper's Messages channel
+	// must only be closed once waitForProcessing has returned, so that
+	// ranging over it observes a clean end-of-stream. Registering this defer
+	// before the one for waitForProcessing ensures it runs after it.
+	var pcWrapper *partitionConsumer
+	defer func() {
+		if pcWrapper != nil {
+			close(pcWrapper.messages)
+		}
+	}()
 	defer pm.waitForProcessing()
 
 	pc, err := pm.startPartitionConsumer(initialOffset)
@@ -62,21 +79,88 @@ func (pm *partitionManager) run() {
 	}
 	defer pm.closePartitionConsumer(pc)
 
+	var highWaterMarkTick <-chan time.Time
+	if pm.parent.config.MetricsRegistry != nil {
+		ticker := time.NewTicker(highWaterMarkRefreshInterval)
+		defer ticker.Stop()
+		highWaterMarkTick = ticker.C
+	}
+
+	// offsetManager is nil when Config.OffsetStore is set; reading its
+	// Errors() channel in that case would panic, so read from a nil channel
+	// (which simply never fires) instead.
+	var offsetManagerErrors <-chan *sarama.ConsumerError
+	if offsetManager != nil {
+		offsetManagerErrors = offsetManager.Errors()
+	}
+
+	if pm.parent.config.Mode == PartitionChannels {
+		pcWrapper = &partitionConsumer{
+			pm:       pm,
+			messages: make(chan *sarama.ConsumerMessage),
+			errors:   make(chan error),
+		}
+
+		select {
+		case pm.parent.partitions <- pcWrapper:
+		case <-pm.t.Dying():
+			return
+		}
+
+		for {
+			select {
+			case <-pm.t.Dying():
+				return
+
+			case <-highWaterMarkTick:
+				pm.refreshHighWaterMark()
+
+			case msg := <-pc.Messages():
+				select {
+				case pcWrapper.messages <- msg:
+					pm.recordConsumed(msg.Offset)
+
+				case <-pm.t.Dying():
+					return
+				}
+
+			case err := <-offsetManagerErrors:
+				select {
+				case pcWrapper.errors <- err:
+					// Noop?
+				case <-pm.t.Dying():
+					return
+				}
+
+			case err := <-pc.Errors():
+				select {
+				case pcWrapper.errors <- err:
+					// Noop?
+				case <-pm.t.Dying():
+					return
+				}
+			}
+		}
+	}
+
 	for {
 		select {
 		case <-pm.t.Dying():
 			return
 
+		case <-highWaterMarkTick:
+			pm.refreshHighWaterMark()
+
 		case msg := <-pc.Messages():
 			select {
 			case pm.parent.messages <- msg:
-				atomic.StoreInt64(&pm.lastConsumedOffset, msg.Offset)
+				pm.recordConsumed(msg.Offset)
 
 			case <-pm.t.Dying():
 				return
 			}
 
-		case err := <-offsetManager.Errors():
+		case err := <-offsetManagerErrors:
 			select {
 			case pm.parent.errors <- err:
 				// Noop?
@@ -95,15 +179,62 @@ func (pm *partitionManager) run() {
 	}
 }
 
+// highWaterMarkRefreshInterval is how often run refreshes the
+// kafka_consumer_high_water_mark metric, when Config.MetricsRegistry is set.
+const highWaterMarkRefreshInterval = 15 * time.Second
+
+// recordConsumed stores offset as the partition's last consumed offset, and
+// reports it to Config.MetricsRegistry when one is configured.
+func (pm *partitionManager) recordConsumed(offset int64) {
+	atomic.StoreInt64(&pm.lastConsumedOffset, offset)
+
+	if metrics := pm.parent.config.MetricsRegistry; metrics != nil {
+		topic := pm.partition.Topic
+		metrics.IncMessagesConsumed(topic, pm.partition.Partition)
+		metrics.SetLastConsumedOffset(topic, pm.partition.Partition, offset)
+	}
+}
+
+// refreshHighWaterMark fetches the partition's current high water mark and
+// reports it to Config.MetricsRegistry, so operators can derive lag as
+// high water mark minus last committed offset.
+func (pm *partitionManager) refreshHighWaterMark() {
+	metrics := pm.parent.config.MetricsRegistry
+	if metrics == nil {
+		return
+	}
+
+	topic := pm.partition.Topic
+	highWaterMark, err := pm.parent.client.GetOffset(topic, pm.partition.Partition, sarama.OffsetNewest)
+	if err != nil {
+		logger.Warn("Failed to refresh high water mark.", pm.fields(Err(err))...)
+		return
+	}
+
+	metrics.SetHighWaterMark(topic, pm.partition.Partition, highWaterMark)
+}
+
+// fields returns the structured fields identifying this partition manager,
+// to be attached to every log line it emits.
+func (pm *partitionManager) fields(extra ...Field) []Field {
+	fields := append([]Field{
+		String("topic", pm.partition.Topic),
+		Int64("partition", int64(pm.partition.Partition)),
+		String("group", pm.parent.groupID),
+		String("instance_id", pm.parent.instanceID),
+	}, extra...)
+	return fields
+}
+
 // startPartitionOffsetManager starts a PartitionOffsetManager for the partition, and will
 // retry any errors. The only error value that can be returned is tomb.ErrDying, which is
 // returned when the partition manager is interrupted. Any other error should be considered
 // non-recoverable.
 func (pm *partitionManager) startPartitionOffsetManager() (sarama.PartitionOffsetManager, error) {
 	for {
-		offsetManager, err := pm.parent.offsetManager.ManagePartition(pm.partition.Topic().Name, pm.partition.ID)
+		offsetManager, err := pm.parent.offsetManager.ManagePartition(pm.partition.Topic, pm.partition.Partition)
 		if err != nil {
-			Logger.Printf("Failed to start partition offset manager for %s: %s. Trying again in 1 second...\n", pm.partition.Key(), err)
+			logger.Warn("Failed to start partition offset manager. Trying again in 1 second...", pm.fields(Err(err))...)
 
 			select {
 			case <-pm.t.Dying():
@@ -117,29 +248,101 @@ func (pm *partitionManager) startPartitionOffsetManager() (sarama.PartitionOffse
 	}
 }
 
+// loadInitialOffset determines the offset consumption should start at, using
+// Config.OffsetStore when it is set, or falling back to the partition's
+// Sarama offset manager otherwise.
+func (pm *partitionManager) loadInitialOffset(offsetManager sarama.PartitionOffsetManager) (int64, error) {
+	var initialOffset int64
+
+	if store := pm.parent.config.OffsetStore; store != nil {
+		offsets, err := store.PartitionOffsets(context.Background(), pm.partition.Topic)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load stored offsets for %s: %s", pm.partition, err)
+		}
+
+		initialOffset = -1
+		for _, po := range offsets {
+			if po.Partition == pm.partition.Partition {
+				initialOffset = po.Offset
+				break
+			}
+		}
+	} else {
+		// We are ignoring metadata for now.
+		initialOffset, _ = offsetManager.Offset()
+	}
+
+	if initialOffset < 0 {
+		return pm.parent.config.Offsets.Initial, nil
+	}
+
+	// Fix the off by one error: we should start consuming once message after the last committed offset
+	return initialOffset + 1, nil
+}
+
 // waitForProcessing waits for all the messages that were consumed for this partition to be processed.
 // The processing can take at most MaxProcessingTime time. After that, those messages are consisered
 // lost and will not be committed. Note that this may cause messages to be processed twice if another
 // partition consumer resumes consuming from this partition later.
 func (pm *partitionManager) waitForProcessing() {
-	lastProcessedOffset, _ := pm.offsetManager.Offset()
+	lastProcessedOffset := pm.lastProcessedOffset()
 	lastConsumedOffset := atomic.LoadInt64(&pm.lastConsumedOffset)
+	start := time.Now()
 
 	if lastConsumedOffset >= 0 {
 		if lastConsumedOffset > lastProcessedOffset {
-			Logger.Printf("Waiting for offset %d to be processed before stopping %s...", lastConsumedOffset, pm.partition.Key())
+			logger.Info("Waiting for offset to be processed before stopping...", pm.fields(
+				Int64("last_consumed_offset", lastConsumedOffset),
+				Int64("last_processed_offset", lastProcessedOffset))...)
 
 			select {
 			case <-pm.processingDone:
-				Logger.Printf("Offset %d has been processed for %s, continuing shutdown.", lastConsumedOffset, pm.partition.Key())
+				logger.Info("Offset has been processed, continuing shutdown.", pm.fields(
+					Int64("last_consumed_offset", lastConsumedOffset))...)
 			case <-time.After(pm.parent.config.MaxProcessingTime):
-
-				Logger.Printf("TIMEOUT: offset %d still has not been processed for %s. The last processed offset was %d.", lastConsumedOffset, pm.partition.Key(), lastProcessedOffset)
+				logger.Warn("TIMEOUT: offset still has not been processed.", pm.fields(
+					Int64("last_consumed_offset", lastConsumedOffset),
+					Int64("last_processed_offset", lastProcessedOffset))...)
 			}
 		} else {
-			Logger.Printf("Offset %d has been processed for %s. Continuing shutdown...", lastConsumedOffset, pm.partition.Key())
+			logger.Info("Offset has already been processed. Continuing shutdown...", pm.fields(
+				Int64("last_consumed_offset", lastConsumedOffset))...)
+		}
+	}
+
+	if metrics := pm.parent.config.MetricsRegistry; metrics != nil {
+		metrics.ObserveProcessingWait(pm.partition.Topic, pm.partition.Partition, time.Since(start).Seconds())
+	}
+}
+
+// lastProcessedOffset reports the last offset known to have been
+// acknowledged for this partition, using Config.OffsetStore when pm.offsetManager
+// was never started (i.e. a store is configured), and pm.offsetManager's own
+// view otherwise. Returns -1 if nothing has been processed yet, or the lookup
+// fails.
+func (pm *partitionManager) lastProcessedOffset() int64 {
+	if pm.offsetManager != nil {
+		offset, _ := pm.offsetManager.Offset()
+		return offset
+	}
+
+	store := pm.parent.config.OffsetStore
+	if store == nil {
+		return -1
+	}
+
+	offsets, err := store.PartitionOffsets(context.Background(), pm.partition.Topic)
+	if err != nil {
+		logger.Warn("Failed to load last processed offset from store.", pm.fields(Err(err))...)
+		return -1
+	}
+
+	for _, po := range offsets {
+		if po.Partition == pm.partition.Partition {
+			return po.Offset
 		}
 	}
+	return -1
 }
 
 // interrupt initiates the shutdown procedure for the partition manager, and returns immediately.
@@ -153,61 +356,66 @@ func (pm *partitionManager) close() error {
 	return pm.t.Wait()
 }
 
-// ack sets the offset on the partition's offset manager, and signals that
-// processing done if the offset is equal to the last consumed offset during shutdown.
+// ack sets the offset on the partition's offset manager, and, once the
+// offset has actually been committed, signals that processing is done if it
+// is equal to the last consumed offset during shutdown.
 func (pm *partitionManager) ack(offset int64) {
-	pm.offsetManager.SetOffset(offset, "")
+	if store := pm.parent.config.OffsetStore; store != nil {
+		if err := store.SetPartitionOffset(context.Background(), pm.partition.Topic, pm.partition.Partition, offset); err != nil {
+			logger.Error("Failed to store offset", pm.fields(Int64("offset", offset), Err(err))...)
+			return
+		}
+	} else {
+		pm.offsetManager.SetOffset(offset, "")
+	}
+
+	if metrics := pm.parent.config.MetricsRegistry; metrics != nil {
+		metrics.SetLastCommittedOffset(pm.partition.Topic, pm.partition.Partition, offset)
+	}
 
 	if pm.t.Err() != tomb.ErrStillAlive && offset == atomic.LoadInt64(&pm.lastConsumedOffset) {
 		close(pm.processingDone)
 	}
 }
 
-// claimPartition claims a partition in Zookeeper for this instance.
-// If the partition is already claimed by someone else, it will wait for the
-// partition to become available. It will retry errors if they occur.
-// This method should therefore only return with a nil error value, or
-// tomb.ErrDying if the partitionManager was interrupted. Any other errors
-// are not recoverable.
+// claimPartition claims this partition for this instance, through
+// pm.parent.coordinator. Under CoordinationZookeeper this waits for any
+// other owner to release the partition first, retrying errors as they occur;
+// under CoordinationKafka it returns immediately, since the group coordinator
+// has already granted exclusive ownership by the time this partitionManager
+// was started. This method should therefore only return with a nil error
+// value, or tomb.ErrDying if the partitionManager was interrupted. Any other
+// errors are not recoverable.
 func (pm *partitionManager) claimPartition() error {
-	Logger.Printf("Trying to claim partition %s...", pm.partition.Key())
-
-	for {
-		owner, changed, err := pm.parent.group.WatchPartitionOwner(pm.partition.Topic().Name, pm.partition.ID)
-		if err != nil {
-			Logger.Printf("Failed to get partition owner for %s from Zookeeper: %s. Trying again in 1 second...", pm.partition.Key(), err)
-			select {
-			case <-time.After(1 * time.Second):
-				continue
-			case <-pm.t.Dying():
-				return tomb.ErrDying
-			}
+	logger.Info("Trying to claim partition...", pm.fields()...)
+
+	// Tie ctx to pm.t.Dying(), so that an interrupted claim (waiting on a
+	// Zookeeper watch, or retrying) actually stops instead of leaking its
+	// goroutine and, for zkCoordinator, its ZK watch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-pm.t.Dying():
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
 
-		if owner != nil {
-			if owner.ID == pm.parent.instance.ID {
-				return fmt.Errorf("The current instance is already the owner of %s. This should not happen.", pm.partition.Key())
-			}
+	done := make(chan error, 1)
+	go func() {
+		done <- pm.parent.coordinator.claimPartition(ctx, pm.partition.Topic, pm.partition.Partition)
+	}()
 
-			Logger.Printf("Partition %s is currently claimed by instance %s. Waiting for it to be released...", pm.partition.Key(), owner.ID)
-			select {
-			case <-changed:
-				continue
-			case <-pm.t.Dying():
-				return tomb.ErrDying
-			}
-
-		} else {
-
-			err := pm.parent.instance.ClaimPartition(pm.partition.Topic().Name, pm.partition.ID)
-			if err != nil {
-				Logger.Printf("Fail to claim ownership for %s: %s. Trying again...", pm.partition.Key(), err)
-				continue
-			}
-
-			Logger.Printf("Claimed ownership for %s", pm.partition.Key())
-			return nil
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
 		}
+		logger.Info("Claimed ownership of partition.", pm.fields()...)
+		return nil
+	case <-pm.t.Dying():
+		return tomb.ErrDying
 	}
 }
 
@@ -222,16 +430,16 @@ func (pm *partitionManager) startPartitionConsumer(initialOffset int64) (sarama.
 	)
 
 	for {
-		pc, err = pm.parent.consumer.ConsumePartition(pm.partition.Topic().Name, pm.partition.ID, initialOffset)
+		pc, err = pm.parent.consumer.ConsumePartition(pm.partition.Topic, pm.partition.Partition, initialOffset)
 		switch err {
 		case nil:
 			switch initialOffset {
 			case sarama.OffsetNewest:
-				Logger.Printf("Started consumer for %s for new messages only.", pm.partition.Key())
+				logger.Info("Started consumer for new messages only.", pm.fields()...)
 			case sarama.OffsetOldest:
-				Logger.Printf("Started consumer for %s at the oldest available offset.", pm.partition.Key())
+				logger.Info("Started consumer at the oldest available offset.", pm.fields()...)
 			default:
-				Logger.Printf("Started consumer for %s at offset %d.", pm.partition.Key(), initialOffset)
+				logger.Info("Started consumer at offset.", pm.fields(Int64("initial_offset", initialOffset))...)
 			}
 
 			// We have a valid partition consumer so we can return
@@ -240,9 +448,11 @@ func (pm *partitionManager) startPartitionConsumer(initialOffset int64) (sarama.
 		case sarama.ErrOffsetOutOfRange:
 			// The offset we had on file is too old. Restart with initial offset
 			if pm.parent.config.Offsets.Initial == sarama.OffsetNewest {
-				Logger.Printf("Offset %d is no longer available for %s. Trying again with new messages only...", initialOffset, pm.partition.Key())
+				logger.Warn("Offset is no longer available. Trying again with new messages only...",
+					pm.fields(Int64("initial_offset", initialOffset))...)
 			} else if pm.parent.config.Offsets.Initial == sarama.OffsetOldest {
-				Logger.Printf("Offset %d is no longer available for %s. Trying again with he oldest available offset...", initialOffset, pm.partition.Key())
+				logger.Warn("Offset is no longer available. Trying again with the oldest available offset...",
+					pm.fields(Int64("initial_offset", initialOffset))...)
 			}
 			initialOffset = pm.parent.config.Offsets.Initial
 
@@ -252,7 +462,7 @@ func (pm *partitionManager) startPartitionConsumer(initialOffset int64) (sarama.
 			// Assume the problem is temporary; just try again.
 			// FIXME: Do we want to treat all errors like this?
 			// FIXME: Should te sleep by configurable?
-			Logger.Printf("Failed to start consuming partition for %s: %s. Trying again in 1 second...\n", pm.partition.Key(), err)
+			logger.Warn("Failed to start consuming partition. Trying again in 1 second...", pm.fields(Err(err))...)
 			select {
 			case <-pm.t.Dying():
 				return nil, tomb.ErrDying
@@ -267,15 +477,13 @@ func (pm *partitionManager) startPartitionConsumer(initialOffset int64) (sarama.
 // closePartitionConsumer closes the sarama consumer for the partition under management.
 func (pm *partitionManager) closePartitionConsumer(pc sarama.PartitionConsumer) {
 	if err := pc.Close(); err != nil {
-		Logger.Printf("Failed to close partition consumer for %s: %s\n", pm.partition.Key(), err)
+		logger.Error("Failed to close partition consumer.", pm.fields(Err(err))...)
 	}
 }
 
-// releasePartition releases this instance's claim on this partition in Zookeeper.
+// releasePartition releases this instance's claim on this partition, through
+// pm.parent.coordinator.
 func (pm *partitionManager) releasePartition() {
-	if err := pm.parent.instance.ReleasePartition(pm.partition.Topic().Name, pm.partition.ID); err != nil {
-		Logger.Printf("FAILED to release partition %s: %s", pm.partition.Key(), err)
-	} else {
-		Logger.Printf("Released partition %s.", pm.partition.Key())
-	}
+	pm.parent.coordinator.releasePartition(context.Background(), pm.partition.Topic, pm.partition.Partition)
+	logger.Info("Released partition.", pm.fields()...)
 }
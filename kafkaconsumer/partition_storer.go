@@ -0,0 +1,109 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// PartitionOffset represents the last committed offset for a single partition.
+type PartitionOffset struct {
+	Partition int32
+	Offset    int64
+}
+
+// PartitionStorer persists consumer offsets. It is the extension point that
+// lets partitionManager commit offsets somewhere other than Kafka/Zookeeper,
+// e.g. alongside the side effects of processing a message in the same SQL
+// transaction. Implementations must be safe for concurrent use by multiple
+// partition managers.
+//
+// When Config.OffsetStore is left nil, partitionManager falls back to its
+// original behavior of committing through Sarama's OffsetManager.
+type PartitionStorer interface {
+	// PartitionOffsets returns the last stored offset for every partition of
+	// topic that has one on record. Partitions with no recorded offset are
+	// simply absent from the result.
+	PartitionOffsets(ctx context.Context, topic string) ([]PartitionOffset, error)
+
+	// SetPartitionOffset records offset as the last processed offset for
+	// partition of topic.
+	SetPartitionOffset(ctx context.Context, topic string, partition int32, offset int64) error
+}
+
+// KafkaPartitionStorer is a PartitionStorer that commits offsets to Kafka
+// (Zookeeper-backed consumer group offsets), using Sarama's OffsetManager.
+// It exists so that Config.OffsetStore can be set explicitly without
+// changing behavior; leaving OffsetStore unset has the same effect.
+type KafkaPartitionStorer struct {
+	client sarama.Client
+	om     sarama.OffsetManager
+
+	mu    sync.Mutex
+	pomes map[string]map[int32]sarama.PartitionOffsetManager
+}
+
+// NewKafkaPartitionStorer wraps om so it can be used as a PartitionStorer.
+// client is used to discover the partitions of a topic for PartitionOffsets.
+func NewKafkaPartitionStorer(client sarama.Client, om sarama.OffsetManager) *KafkaPartitionStorer {
+	return &KafkaPartitionStorer{
+		client: client,
+		om:     om,
+		pomes:  make(map[string]map[int32]sarama.PartitionOffsetManager),
+	}
+}
+
+func (s *KafkaPartitionStorer) partitionOffsetManager(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pomes[topic] == nil {
+		s.pomes[topic] = make(map[int32]sarama.PartitionOffsetManager)
+	}
+	if pom, ok := s.pomes[topic][partition]; ok {
+		return pom, nil
+	}
+
+	pom, err := s.om.ManagePartition(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	s.pomes[topic][partition] = pom
+	return pom, nil
+}
+
+// PartitionOffsets implements PartitionStorer.
+func (s *KafkaPartitionStorer) PartitionOffsets(ctx context.Context, topic string) ([]PartitionOffset, error) {
+	partitions, err := s.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions for %s: %s", topic, err)
+	}
+
+	offsets := make([]PartitionOffset, 0, len(partitions))
+	for _, partition := range partitions {
+		pom, err := s.partitionOffsetManager(topic, partition)
+		if err != nil {
+			return nil, err
+		}
+
+		offset, _ := pom.Offset()
+		if offset >= 0 {
+			offsets = append(offsets, PartitionOffset{Partition: partition, Offset: offset})
+		}
+	}
+
+	return offsets, nil
+}
+
+// SetPartitionOffset implements PartitionStorer.
+func (s *KafkaPartitionStorer) SetPartitionOffset(ctx context.Context, topic string, partition int32, offset int64) error {
+	pom, err := s.partitionOffsetManager(topic, partition)
+	if err != nil {
+		return err
+	}
+
+	pom.SetOffset(offset, "")
+	return nil
+}
@@ -0,0 +1,88 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLPartitionStorer is a PartitionStorer backed by a database/sql table:
+//
+//	CREATE TABLE kafka_consumer_offsets (
+//		topic       TEXT,
+//		`partition` INT,
+//		`offset`    BIGINT,
+//		PRIMARY KEY(topic, `partition`)
+//	)
+//
+// The table name is configurable so it can be created by users' own
+// migrations; it defaults to "kafka_consumer_offsets". `partition` and
+// `offset` are both reserved words in MySQL (the dialect this storer
+// targets, given its use of ON DUPLICATE KEY UPDATE), so every query quotes
+// them with backticks.
+//
+// SetPartitionOffset issues its own statement against db, which is usually a
+// *sql.DB. To commit an offset atomically alongside other side effects, call
+// SetPartitionOffsetTx with a caller-managed *sql.Tx instead, so the offset
+// update is part of the same transaction.
+type SQLPartitionStorer struct {
+	db    sqlExecutor
+	table string
+}
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// NewSQLPartitionStorer returns a PartitionStorer that commits offsets to
+// table using db. db may be a *sql.DB or a *sql.Tx; passing a *sql.Tx lets
+// callers commit offsets in the same transaction as their processing side
+// effects. table defaults to "kafka_consumer_offsets" when empty.
+func NewSQLPartitionStorer(db sqlExecutor, table string) *SQLPartitionStorer {
+	if table == "" {
+		table = "kafka_consumer_offsets"
+	}
+	return &SQLPartitionStorer{db: db, table: table}
+}
+
+// PartitionOffsets implements PartitionStorer.
+func (s *SQLPartitionStorer) PartitionOffsets(ctx context.Context, topic string) ([]PartitionOffset, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+"`partition`, `offset`"+` FROM `+s.table+` WHERE topic = ?`, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offsets []PartitionOffset
+	for rows.Next() {
+		var po PartitionOffset
+		if err := rows.Scan(&po.Partition, &po.Offset); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, po)
+	}
+	return offsets, rows.Err()
+}
+
+// SetPartitionOffset implements PartitionStorer by upserting the offset in a
+// single statement against db. It runs inside a transaction only when db
+// itself is a *sql.Tx.
+func (s *SQLPartitionStorer) SetPartitionOffset(ctx context.Context, topic string, partition int32, offset int64) error {
+	_, err := s.db.ExecContext(ctx, s.upsertQuery(), topic, partition, offset)
+	return err
+}
+
+// SetPartitionOffsetTx upserts the offset as part of tx, so it commits
+// atomically alongside whatever other statements tx performs while
+// processing the message that produced offset.
+func (s *SQLPartitionStorer) SetPartitionOffsetTx(ctx context.Context, tx *sql.Tx, topic string, partition int32, offset int64) error {
+	_, err := tx.ExecContext(ctx, s.upsertQuery(), topic, partition, offset)
+	return err
+}
+
+func (s *SQLPartitionStorer) upsertQuery() string {
+	return `
+		INSERT INTO ` + s.table + ` (topic, ` + "`partition`, `offset`" + `) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE ` + "`offset`" + ` = VALUES(` + "`offset`" + `)`
+}
@@ -0,0 +1,56 @@
+package kafkaconsumer
+
+import "github.com/Shopify/sarama"
+
+// ConsumerMode selects how a Consumer hands its assigned partitions'
+// messages off to the application.
+type ConsumerMode int
+
+const (
+	// SharedChannel funnels every partition's messages into a single
+	// Consumer.Messages() channel. This is this package's original
+	// behavior, and remains the default.
+	SharedChannel ConsumerMode = iota
+
+	// PartitionChannels gives each assigned partition its own message
+	// channel, via Consumer.Partitions(), so applications can process
+	// partitions concurrently while still preserving per-partition order.
+	PartitionChannels
+)
+
+// PartitionConsumer is a single assigned partition's own message stream, used
+// with Config.Mode == PartitionChannels. Its Messages channel is closed once
+// the partition manager has finished waiting for in-flight messages to be
+// acknowledged, so ranging over it cleanly observes end-of-stream.
+type PartitionConsumer interface {
+	Topic() string
+	Partition() int32
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan error
+	Ack(offset int64)
+}
+
+// partitionConsumer is partitionManager's implementation of PartitionConsumer.
+type partitionConsumer struct {
+	pm       *partitionManager
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+}
+
+func (pc *partitionConsumer) Topic() string    { return pc.pm.partition.Topic }
+func (pc *partitionConsumer) Partition() int32 { return pc.pm.partition.Partition }
+
+func (pc *partitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *partitionConsumer) Errors() <-chan error                     { return pc.errors }
+
+// Ack acknowledges offset as processed for this partition.
+func (pc *partitionConsumer) Ack(offset int64) {
+	pc.pm.ack(offset)
+}
+
+// Partitions returns a channel of PartitionConsumer, one per partition
+// assigned to this consumer, when Config.Mode == PartitionChannels. It is
+// nil when the consumer is running in the default SharedChannel mode.
+func (c *Consumer) Partitions() <-chan PartitionConsumer {
+	return c.partitions
+}